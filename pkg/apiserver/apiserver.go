@@ -0,0 +1,446 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apiserver contains the generic REST plumbing shared by every
+// kind the master exposes: a thin dispatcher that maps
+// "/prefix/version/{kind}[/{id}]" onto a RESTStorage implementation,
+// JSON encodes/decodes api objects on the wire, and turns storage errors
+// into the right HTTP status code.
+package apiserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/operations"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// defaultAsyncOpWait is how long a Create/Update/Delete call blocks hoping
+// the underlying work finishes before we fall back to a 202 Accepted plus
+// an operation the client can poll.
+const defaultAsyncOpWait = 5 * time.Second
+
+// RESTStorage is implemented by each kind that wants to expose itself
+// through the apiserver. id is the kind-relative resource name (e.g. a pod
+// name); obj is always the decoded api object for that kind. ctx carries
+// the deadline derived from the request's ?timeout= and is cancelled if
+// the client disconnects, so implementations should pass it down into any
+// etcd call, kubelet RPC, etc. they make.
+type RESTStorage interface {
+	List(ctx context.Context, label labels.Selector, field fields.Selector) (interface{}, error)
+	Get(ctx context.Context, id string) (interface{}, error)
+	Delete(ctx context.Context, id string) (<-chan interface{}, error)
+	Extract(body []byte) (interface{}, error)
+	Create(ctx context.Context, obj interface{}) (<-chan interface{}, error)
+	Update(ctx context.Context, obj interface{}) (<-chan interface{}, error)
+}
+
+// ResourceWatcher is optionally implemented by a RESTStorage that can
+// stream changes to its resources.
+type ResourceWatcher interface {
+	WatchAll(ctx context.Context, label labels.Selector, field fields.Selector) (watch.Interface, error)
+	WatchSingle(ctx context.Context, id string) (watch.Interface, error)
+}
+
+// AttrGetter is optionally implemented by a RESTStorage whose objects
+// carry labels and fields that List/Watch selectors can be matched
+// against. A storage that doesn't implement it simply isn't filterable.
+type AttrGetter interface {
+	GetAttrs(obj interface{}) (labels.Labels, fields.Fields, error)
+}
+
+// WorkFunc is a unit of (usually slow) work whose result should be
+// delivered asynchronously. See MakeAsyncCtx.
+type WorkFunc func() (interface{}, error)
+
+// workResult carries a WorkFunc's return values across a channel.
+type workResult struct {
+	obj interface{}
+	err error
+}
+
+// MakeAsyncCtx runs fn on its own goroutine and returns a channel that
+// receives its result exactly once: either fn's own return value, or, if
+// ctx is done first (its deadline passed or the client disconnected),
+// ctx.Err(). fn itself keeps running to completion even after ctx fires —
+// it is fn's responsibility to watch ctx if it wants to abort early.
+func MakeAsyncCtx(ctx context.Context, fn WorkFunc) <-chan interface{} {
+	ch := make(chan interface{}, 1)
+	resultCh := make(chan workResult, 1)
+	go func() {
+		obj, err := fn()
+		resultCh <- workResult{obj, err}
+	}()
+	go func() {
+		select {
+		case result := <-resultCh:
+			if result.err != nil {
+				ch <- &apiStatusError{result.err}
+			} else {
+				ch <- result.obj
+			}
+		case <-ctx.Done():
+			ch <- &apiStatusError{ctx.Err()}
+		}
+	}()
+	return ch
+}
+
+// apiStatusError lets a WorkFunc's error ride down the same channel as a
+// successful result without losing its type.
+type apiStatusError struct {
+	error
+}
+
+// Filter wraps an http.Handler to add cross-cutting behavior (authn,
+// request logging, metrics, ...) around every request the APIServer
+// serves. Filters sit in front of the mux, so they apply equally to the
+// REST, /operations and ?watch=true endpoints.
+type Filter func(http.Handler) http.Handler
+
+// APIServer is an HTTP handler that dispatches to a set of RESTStorage
+// implementations mounted under a common prefix (e.g. "/api/v1beta1").
+type APIServer struct {
+	mux         *http.ServeMux
+	handler     http.Handler
+	filters     []Filter
+	storage     map[string]RESTStorage
+	prefix      string
+	ops         *operations.Operations
+	asyncOpWait time.Duration
+}
+
+// New creates a new APIServer that serves the given storage map beneath
+// prefix. Each top level path segment after prefix selects a RESTStorage
+// by name. filters, if given, are applied around every request in
+// registration order; see WithFilters to add more after construction.
+func New(storage map[string]RESTStorage, prefix string, filters ...Filter) *APIServer {
+	s := &APIServer{
+		mux:         http.NewServeMux(),
+		storage:     storage,
+		prefix:      strings.TrimRight(prefix, "/"),
+		ops:         operations.NewOperations(0, 0),
+		asyncOpWait: defaultAsyncOpWait,
+	}
+	s.init()
+	s.handler = s.mux
+	return s.WithFilters(filters...)
+}
+
+func (s *APIServer) init() {
+	s.mux.HandleFunc(s.prefix+"/operations", s.handleOperationsList)
+	s.mux.HandleFunc(s.prefix+"/operations/", s.handleOperation)
+	s.mux.HandleFunc(s.prefix+"/", s.handleREST)
+}
+
+// WithFilters appends the given filters to any already applied (by New or
+// an earlier WithFilters call) and rebuilds s's handler so that all of
+// them run in registration order around the mux, the first registered
+// outermost. It returns s so callers that built an APIServer with
+// New(storage, prefix) can still add filters afterward without
+// reconstructing it.
+func (s *APIServer) WithFilters(filters ...Filter) *APIServer {
+	s.filters = append(s.filters, filters...)
+	s.handler = s.mux
+	for i := len(s.filters) - 1; i >= 0; i-- {
+		s.handler = s.filters[i](s.handler)
+	}
+	return s
+}
+
+func (s *APIServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer clearRequestContext(req)
+	s.handler.ServeHTTP(w, req)
+}
+
+// handleREST dispatches "/prefix/{kind}" and "/prefix/{kind}/{id}".
+func (s *APIServer) handleREST(w http.ResponseWriter, req *http.Request) {
+	parts := splitPath(strings.TrimPrefix(req.URL.Path, s.prefix))
+	if len(parts) == 0 {
+		notFound(w, req)
+		return
+	}
+	kind := parts[0]
+	storage, ok := s.storage[kind]
+	if !ok {
+		notFound(w, req)
+		return
+	}
+
+	var id string
+	if len(parts) >= 2 {
+		id = parts[1]
+	}
+
+	if req.Method == "GET" {
+		labelSelector, fieldSelector, err := parseSelectors(req)
+		if err != nil {
+			s.write(w, http.StatusOK, nil, newBadRequestErr(err.Error()))
+			return
+		}
+		if req.FormValue("watch") == "true" {
+			watchID := id
+			if watchID == "" {
+				watchID = req.FormValue("id")
+			}
+			// A watch is long-lived by design, so it isn't bound by the
+			// ?timeout= deadline the other verbs get from requestContext —
+			// only an explicit client disconnect ends it.
+			ctx, cancel := s.disconnectContext(req, w)
+			defer cancel()
+			s.doWatch(ctx, w, req, storage, watchID, labelSelector, fieldSelector)
+			return
+		}
+		ctx := s.requestContext(req, w)
+		if id == "" {
+			s.doList(ctx, w, req, storage, labelSelector, fieldSelector)
+		} else {
+			s.doGet(ctx, w, req, storage, id)
+		}
+		return
+	}
+
+	ctx := s.requestContext(req, w)
+
+	switch req.Method {
+	case "POST":
+		s.doCreate(ctx, w, req, storage)
+	case "PUT":
+		s.doUpdate(ctx, w, req, storage, id)
+	case "DELETE":
+		s.doDelete(ctx, w, req, storage, id)
+	default:
+		notFound(w, req)
+	}
+}
+
+// disconnectContext returns a context, derived from ContextFor(req) (and
+// so carrying any value a Filter like Authenticator stashed there) with no
+// deadline that is cancelled only when w's http.CloseNotifier fires, for
+// handlers (like watch) that should run until the client goes away rather
+// than until ?timeout=. Since this context has no deadline of its own, the
+// caller must call the returned cancel once it's done with ctx (e.g. via
+// defer), or the monitor goroutine leaks for as long as the connection
+// stays open.
+func (s *APIServer) disconnectContext(req *http.Request, w http.ResponseWriter) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ContextFor(req))
+	var closeCh <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeCh = notifier.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closeCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// requestContext derives a context for req, from ContextFor(req), whose
+// deadline is its ?timeout= value (defaulting as parseTimeout does) and
+// which is cancelled early if the client closes the connection before
+// that deadline, via w's http.CloseNotifier.
+func (s *APIServer) requestContext(req *http.Request, w http.ResponseWriter) context.Context {
+	timeout := parseTimeout(req.FormValue("timeout"))
+	ctx, cancel := context.WithTimeout(ContextFor(req), timeout)
+
+	var closeCh <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeCh = notifier.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closeCh:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	return ctx
+}
+
+// parseSelectors reads the ?labels= and ?fields= query parameters off
+// req, defaulting either to its Everything() selector if absent.
+func parseSelectors(req *http.Request) (labels.Selector, fields.Selector, error) {
+	labelSelector := labels.Everything()
+	if str := req.FormValue("labels"); str != "" {
+		selector, err := labels.ParseSelector(str)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing labels selector: %v", err)
+		}
+		labelSelector = selector
+	}
+	fieldSelector := fields.Everything()
+	if str := req.FormValue("fields"); str != "" {
+		selector, err := fields.ParseSelector(str)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing fields selector: %v", err)
+		}
+		fieldSelector = selector
+	}
+	return labelSelector, fieldSelector, nil
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+func notFound(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprintf(w, "%s not found", req.URL.Path)
+}
+
+func (s *APIServer) doList(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage, label labels.Selector, field fields.Selector) {
+	result, err := storage.List(ctx, label, field)
+	s.write(w, http.StatusOK, result, err)
+}
+
+func (s *APIServer) doGet(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage, id string) {
+	result, err := storage.Get(ctx, id)
+	s.write(w, http.StatusOK, result, err)
+}
+
+func (s *APIServer) doCreate(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	obj, err := storage.Extract(body)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	channel, err := storage.Create(ctx, obj)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	s.finishReq(ctx, w, req, "create", channel)
+}
+
+func (s *APIServer) doUpdate(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage, id string) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	obj, err := storage.Extract(body)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	channel, err := storage.Update(ctx, obj)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	s.finishReq(ctx, w, req, "update", channel)
+}
+
+func (s *APIServer) doDelete(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage, id string) {
+	channel, err := storage.Delete(ctx, id)
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	s.finishReq(ctx, w, req, "delete", channel)
+}
+
+// finishReq registers the in-flight work as an Operation and either
+// answers synchronously (the work finished within the allotted wait) or
+// responds 202 Accepted with the operation the client can poll, setting
+// Location to the operation's own URL so the client doesn't have to build
+// it from Details by hand.
+//
+// sync=true makes the HTTP response wait up to ctx's own ?timeout=
+// deadline, so it reuses ctx itself rather than starting an independent
+// timer of the same length: ctx is the very context MakeAsyncCtx is
+// racing against the work function, and WaitFor's doc explains why
+// sharing it (instead of merely matching its duration) is what makes the
+// 202 fallback deterministic. Without sync=true, the response instead
+// waits up to the server's default asyncOpWait, which has nothing to do
+// with ctx's deadline, so it gets its own independent context.
+func (s *APIServer) finishReq(ctx context.Context, w http.ResponseWriter, req *http.Request, kind string, channel <-chan interface{}) {
+	op := s.ops.NewOperation(channel, kind)
+
+	waitCtx := ctx
+	if req.FormValue("sync") != "true" {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(context.Background(), s.asyncOpWait)
+		defer cancel()
+	}
+
+	result, ok := op.WaitFor(waitCtx)
+	if !ok {
+		status := api.Status{Status: api.StatusWorking, Details: op.ID}
+		w.Header().Set("Location", s.prefix+"/operations/"+op.ID)
+		s.write(w, http.StatusAccepted, status, nil)
+		return
+	}
+	if err, isErr := result.(error); isErr {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	s.write(w, http.StatusOK, result, nil)
+}
+
+func (s *APIServer) write(w http.ResponseWriter, successStatusCode int, obj interface{}, err error) {
+	if err != nil {
+		code, msg := errToAPIStatus(err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		body, _ := api.Encode(api.Status{Status: api.StatusFailure, Details: msg})
+		w.Write(body)
+		return
+	}
+	body, err := api.Encode(obj)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(successStatusCode)
+	w.Write(body)
+}
+
+// parseTimeout parses the ?timeout= query value, defaulting to 30s if it
+// is missing or malformed.
+func parseTimeout(str string) time.Duration {
+	if str != "" {
+		timeout, err := time.ParseDuration(str)
+		if err == nil {
+			return timeout
+		}
+	}
+	return 30 * time.Second
+}
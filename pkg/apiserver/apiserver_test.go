@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -28,7 +29,10 @@ import (
 	"testing"
 	"time"
 
+	"code.google.com/p/go.net/context"
+
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
 	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
 )
@@ -51,7 +55,8 @@ func expectNoError(t *testing.T, err error) {
 
 type Simple struct {
 	api.JSONBase `yaml:",inline" json:",inline"`
-	Name         string `yaml:"name,omitempty" json:"name,omitempty"`
+	Name         string            `yaml:"name,omitempty" json:"name,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
 }
 
 type SimpleList struct {
@@ -70,33 +75,64 @@ type SimpleRESTStorage struct {
 	// Valid if WatchAll or WatchSingle is called
 	fakeWatch *watch.FakeWatcher
 
+	// Wraps fakeWatch so tests can observe Stop() from a different
+	// goroutine without racing FakeWatcher's own unsynchronized Stopped
+	// field; valid alongside fakeWatch.
+	watcher *stopNotifier
+
 	// Set if WatchSingle is called
 	requestedID string
 
+	// Set by List and WatchAll, so tests can assert on what they were invoked with.
+	requestedLabelSelector labels.Selector
+	requestedFieldSelector fields.Selector
+
 	// If non-nil, called inside the WorkFunc when answering update, delete, create.
 	// obj receives the original input to the update, delete, or create call.
-	injectedFunction func(obj interface{}) (returnObj interface{}, err error)
+	injectedFunction func(ctx context.Context, obj interface{}) (returnObj interface{}, err error)
+}
+
+// GetAttrs lets the apiserver match List/Watch selectors against a Simple:
+// its Labels map as labels, and its Name as the "name" field.
+func (storage *SimpleRESTStorage) GetAttrs(obj interface{}) (labels.Labels, fields.Fields, error) {
+	simple, ok := obj.(Simple)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected Simple, got %#v", obj)
+	}
+	return labels.Set(simple.Labels), fields.Set{"name": simple.Name}, nil
 }
 
-func (storage *SimpleRESTStorage) List(labels.Selector) (interface{}, error) {
+func (storage *SimpleRESTStorage) List(ctx context.Context, label labels.Selector, field fields.Selector) (interface{}, error) {
+	storage.requestedLabelSelector = label
+	storage.requestedFieldSelector = field
+	items := []Simple{}
+	for _, item := range storage.list {
+		ls, fs, err := storage.GetAttrs(item)
+		if err != nil {
+			return nil, err
+		}
+		if label.Matches(ls) && field.Matches(fs) {
+			items = append(items, item)
+		}
+	}
 	result := &SimpleList{
-		Items: storage.list,
+		Items: items,
 	}
 	return result, storage.errors["list"]
 }
 
-func (storage *SimpleRESTStorage) Get(id string) (interface{}, error) {
+func (storage *SimpleRESTStorage) Get(ctx context.Context, id string) (interface{}, error) {
 	return storage.item, storage.errors["get"]
 }
 
-func (storage *SimpleRESTStorage) Delete(id string) (<-chan interface{}, error) {
+func (storage *SimpleRESTStorage) Delete(ctx context.Context, id string) (<-chan interface{}, error) {
 	storage.deleted = id
 	if err := storage.errors["delete"]; err != nil {
 		return nil, err
 	}
-	return MakeAsync(func() (interface{}, error) {
+	return MakeAsyncCtx(ctx, func() (interface{}, error) {
 		if storage.injectedFunction != nil {
-			return storage.injectedFunction(id)
+			return storage.injectedFunction(ctx, id)
 		}
 		return api.Status{Status: api.StatusSuccess}, nil
 	}), nil
@@ -108,49 +144,71 @@ func (storage *SimpleRESTStorage) Extract(body []byte) (interface{}, error) {
 	return item, storage.errors["extract"]
 }
 
-func (storage *SimpleRESTStorage) Create(obj interface{}) (<-chan interface{}, error) {
+func (storage *SimpleRESTStorage) Create(ctx context.Context, obj interface{}) (<-chan interface{}, error) {
 	storage.created = obj.(Simple)
 	if err := storage.errors["create"]; err != nil {
 		return nil, err
 	}
-	return MakeAsync(func() (interface{}, error) {
+	return MakeAsyncCtx(ctx, func() (interface{}, error) {
 		if storage.injectedFunction != nil {
-			return storage.injectedFunction(obj)
+			return storage.injectedFunction(ctx, obj)
 		}
 		return obj, nil
 	}), nil
 }
 
-func (storage *SimpleRESTStorage) Update(obj interface{}) (<-chan interface{}, error) {
+func (storage *SimpleRESTStorage) Update(ctx context.Context, obj interface{}) (<-chan interface{}, error) {
 	storage.updated = obj.(Simple)
 	if err := storage.errors["update"]; err != nil {
 		return nil, err
 	}
-	return MakeAsync(func() (interface{}, error) {
+	return MakeAsyncCtx(ctx, func() (interface{}, error) {
 		if storage.injectedFunction != nil {
-			return storage.injectedFunction(obj)
+			return storage.injectedFunction(ctx, obj)
 		}
 		return obj, nil
 	}), nil
 }
 
 // Implement ResourceWatcher.
-func (storage *SimpleRESTStorage) WatchAll() (watch.Interface, error) {
+func (storage *SimpleRESTStorage) WatchAll(ctx context.Context, label labels.Selector, field fields.Selector) (watch.Interface, error) {
+	storage.requestedLabelSelector = label
+	storage.requestedFieldSelector = field
 	if err := storage.errors["watchAll"]; err != nil {
 		return nil, err
 	}
 	storage.fakeWatch = watch.NewFake()
-	return storage.fakeWatch, nil
+	storage.watcher = newStopNotifier(storage.fakeWatch)
+	return storage.watcher, nil
 }
 
 // Implement ResourceWatcher.
-func (storage *SimpleRESTStorage) WatchSingle(id string) (watch.Interface, error) {
+func (storage *SimpleRESTStorage) WatchSingle(ctx context.Context, id string) (watch.Interface, error) {
 	storage.requestedID = id
 	if err := storage.errors["watchSingle"]; err != nil {
 		return nil, err
 	}
 	storage.fakeWatch = watch.NewFake()
-	return storage.fakeWatch, nil
+	storage.watcher = newStopNotifier(storage.fakeWatch)
+	return storage.watcher, nil
+}
+
+// stopNotifier wraps a watch.Interface to report, via a channel a test can
+// safely select on, when Stop has been called -- instead of a test having
+// to poll watch.FakeWatcher's own Stopped field, which it sets with no
+// synchronization.
+type stopNotifier struct {
+	watch.Interface
+	stopped chan struct{}
+}
+
+func newStopNotifier(w watch.Interface) *stopNotifier {
+	return &stopNotifier{Interface: w, stopped: make(chan struct{})}
+}
+
+func (n *stopNotifier) Stop() {
+	n.Interface.Stop()
+	close(n.stopped)
 }
 
 func extractBody(response *http.Response, object interface{}) (string, error) {
@@ -229,6 +287,63 @@ func TestNonEmptyList(t *testing.T) {
 	}
 }
 
+func TestListSelectsOnLabelAndField(t *testing.T) {
+	storage := map[string]RESTStorage{}
+	simpleStorage := SimpleRESTStorage{
+		list: []Simple{
+			{Name: "foo", Labels: map[string]string{"env": "prod"}},
+			{Name: "bar", Labels: map[string]string{"env": "test"}},
+		},
+	}
+	storage["simple"] = &simpleStorage
+	handler := New(storage, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple?labels=env!=test&fields=name=foo")
+	expectNoError(t, err)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected status: %d, Expected: %d, %#v", resp.StatusCode, http.StatusOK, resp)
+	}
+
+	var listOut SimpleList
+	_, err = extractBody(resp, &listOut)
+	expectNoError(t, err)
+	if len(listOut.Items) != 1 || listOut.Items[0].Name != "foo" {
+		t.Errorf("Unexpected response: %#v", listOut)
+	}
+	if simpleStorage.requestedLabelSelector.String() != "env!=test" {
+		t.Errorf("Unexpected label selector: %v", simpleStorage.requestedLabelSelector)
+	}
+	if simpleStorage.requestedFieldSelector.String() != "name=foo" {
+		t.Errorf("Unexpected field selector: %v", simpleStorage.requestedFieldSelector)
+	}
+}
+
+func TestListInvalidSelectorsAreBadRequests(t *testing.T) {
+	storage := map[string]RESTStorage{}
+	simpleStorage := SimpleRESTStorage{}
+	storage["simple"] = &simpleStorage
+	handler := New(storage, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, query := range []string{"labels=foo", "fields=foo"} {
+		resp, err := http.Get(server.URL + "/prefix/version/simple?" + query)
+		expectNoError(t, err)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("query %q: expected status %d, got %d", query, http.StatusBadRequest, resp.StatusCode)
+		}
+		var status api.Status
+		_, err = extractBody(resp, &status)
+		expectNoError(t, err)
+		if status.Status != api.StatusFailure {
+			t.Errorf("query %q: expected a failure status, got %#v", query, status)
+		}
+	}
+}
+
 func TestGet(t *testing.T) {
 	storage := map[string]RESTStorage{}
 	simpleStorage := SimpleRESTStorage{
@@ -457,7 +572,7 @@ func TestParseTimeout(t *testing.T) {
 
 func TestSyncCreate(t *testing.T) {
 	storage := SimpleRESTStorage{
-		injectedFunction: func(obj interface{}) (interface{}, error) {
+		injectedFunction: func(ctx context.Context, obj interface{}) (interface{}, error) {
 			time.Sleep(200 * time.Millisecond)
 			return obj, nil
 		},
@@ -496,7 +611,7 @@ func TestSyncCreate(t *testing.T) {
 
 func TestSyncCreateTimeout(t *testing.T) {
 	storage := SimpleRESTStorage{
-		injectedFunction: func(obj interface{}) (interface{}, error) {
+		injectedFunction: func(ctx context.Context, obj interface{}) (interface{}, error) {
 			time.Sleep(400 * time.Millisecond)
 			return obj, nil
 		},
@@ -530,3 +645,80 @@ func TestSyncCreateTimeout(t *testing.T) {
 		t.Errorf("Unexpected status: %d, Expected: %d, %#v", response.StatusCode, 202, response)
 	}
 }
+
+func TestSyncCreateClientDisconnect(t *testing.T) {
+	canceled := make(chan error, 1)
+	storage := SimpleRESTStorage{
+		injectedFunction: func(ctx context.Context, obj interface{}) (interface{}, error) {
+			select {
+			case <-ctx.Done():
+				canceled <- ctx.Err()
+			case <-time.After(2 * time.Second):
+				canceled <- fmt.Errorf("context was never canceled")
+			}
+			return obj, nil
+		},
+	}
+	handler := New(map[string]RESTStorage{"foo": &storage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	simple := Simple{Name: "foo"}
+	data, _ := api.Encode(simple)
+	request := fmt.Sprintf("POST /prefix/version/foo?sync=true HTTP/1.1\r\nHost: %s\r\nContent-Length: %d\r\n\r\n%s",
+		server.Listener.Addr().String(), len(data), data)
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	expectNoError(t, err)
+	_, err = conn.Write([]byte(request))
+	expectNoError(t, err)
+
+	// Give the handler a moment to start, then hang up before it answers.
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-canceled:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the injected function to observe cancellation")
+	}
+}
+
+func TestAsyncCreateContextTimeout(t *testing.T) {
+	deadlineFired := make(chan bool, 1)
+	storage := SimpleRESTStorage{
+		injectedFunction: func(ctx context.Context, obj interface{}) (interface{}, error) {
+			select {
+			case <-ctx.Done():
+				deadlineFired <- true
+			case <-time.After(time.Second):
+				deadlineFired <- false
+			}
+			return obj, nil
+		},
+	}
+	handler := New(map[string]RESTStorage{"foo": &storage}, "/prefix/version")
+	handler.asyncOpWait = 0
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	simple := Simple{Name: "foo"}
+	data, _ := api.Encode(simple)
+	resp, err := http.Post(server.URL+"/prefix/version/foo?timeout=100ms", "application/json", bytes.NewBuffer(data))
+	expectNoError(t, err)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Unexpected status: %d, Expected: %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	select {
+	case fired := <-deadlineFired:
+		if !fired {
+			t.Errorf("expected ctx.Done() to fire from the ?timeout= deadline even without sync=true")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the injected function to observe the deadline")
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"net/http"
+	"sync"
+
+	"code.google.com/p/go.net/context"
+)
+
+// userContextKey is the context.Context key WithUser/UserFrom use. It's an
+// unexported type so no other package can collide with it.
+type userContextKey struct{}
+
+// WithUser returns a copy of ctx that carries user as the request's
+// authenticated identity, retrievable with UserFrom. A Filter that
+// authenticates requests (see apiserver/filters.NewAuthenticator) calls this
+// and threads the result through SetRequestContext so RESTStorage methods
+// can recover it from the ctx they're handed.
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
+
+// UserFrom returns the user a Filter stashed in ctx with WithUser, and
+// whether one was present.
+func UserFrom(ctx context.Context) (string, bool) {
+	user, ok := ctx.Value(userContextKey{}).(string)
+	return user, ok
+}
+
+// requestContexts lets a Filter thread facts it derives about req (most
+// importantly the authenticated user) into the context.Context that
+// requestContext/disconnectContext later hand to RESTStorage. req
+// predates context support in this tree, so there's no field on it to
+// carry this; a map keyed by the *http.Request pointer, cleared once
+// ServeHTTP returns, stands in for one.
+var requestContexts = struct {
+	sync.Mutex
+	byRequest map[*http.Request]context.Context
+}{byRequest: map[*http.Request]context.Context{}}
+
+// SetRequestContext associates ctx with req for the remainder of the
+// request's lifetime, so that a later call to ContextFor(req) returns it.
+func SetRequestContext(req *http.Request, ctx context.Context) {
+	requestContexts.Lock()
+	defer requestContexts.Unlock()
+	requestContexts.byRequest[req] = ctx
+}
+
+// ContextFor returns the context a Filter associated with req via
+// SetRequestContext, or context.Background() if none did.
+func ContextFor(req *http.Request) context.Context {
+	requestContexts.Lock()
+	ctx, ok := requestContexts.byRequest[req]
+	requestContexts.Unlock()
+	if !ok {
+		return context.Background()
+	}
+	return ctx
+}
+
+// clearRequestContext discards the context association for req, if any.
+// APIServer.ServeHTTP defers this so the map doesn't grow unboundedly.
+func clearRequestContext(req *http.Request) {
+	requestContexts.Lock()
+	delete(requestContexts.byRequest, req)
+	requestContexts.Unlock()
+}
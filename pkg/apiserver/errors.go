@@ -0,0 +1,77 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// notFoundErr is returned by RESTStorage implementations when the requested
+// resource does not exist. The apiserver translates it into a 404 response.
+type notFoundErr struct {
+	kind string
+	id   string
+}
+
+func (e *notFoundErr) Error() string {
+	return fmt.Sprintf("%s %q not found", e.kind, e.id)
+}
+
+// NewNotFoundErr returns a new error indicating the resource of the given
+// kind and id could not be found.
+func NewNotFoundErr(kind, id string) error {
+	return &notFoundErr{kind: kind, id: id}
+}
+
+// IsNotFound returns true if the given error indicates a resource was not
+// found.
+func IsNotFound(err error) bool {
+	_, ok := err.(*notFoundErr)
+	return ok
+}
+
+// badRequestErr is returned for requests the apiserver itself rejects
+// before reaching storage, e.g. an unparseable label or field selector.
+type badRequestErr string
+
+func (e badRequestErr) Error() string { return string(e) }
+
+// newBadRequestErr returns a new error that the apiserver translates into
+// a 400 response.
+func newBadRequestErr(msg string) error {
+	return badRequestErr(msg)
+}
+
+// isBadRequest returns true if the given error indicates a malformed request.
+func isBadRequest(err error) bool {
+	_, ok := err.(badRequestErr)
+	return ok
+}
+
+// errToAPIStatus maps an error returned by a RESTStorage implementation to
+// the HTTP status code that should be written in response.
+func errToAPIStatus(err error) (int, string) {
+	switch {
+	case IsNotFound(err):
+		return http.StatusNotFound, err.Error()
+	case isBadRequest(err):
+		return http.StatusBadRequest, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package filters provides the apiserver.Filter implementations every
+// binary embedding the apiserver tends to need: request logging,
+// pluggable authentication, and panic recovery.
+package filters
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/apiserver"
+)
+
+// Authenticator authenticates an incoming request, returning the
+// requester's identity. ok is false with a nil err for a request that
+// simply carries no credentials; err is reserved for a request whose
+// credentials are present but invalid.
+type Authenticator interface {
+	AuthenticateRequest(req *http.Request) (user string, ok bool, err error)
+}
+
+// NewAuthenticator returns a Filter that rejects any request auth can't
+// authenticate with 401, and otherwise stashes the authenticated user in
+// the context.Context threaded down to RESTStorage (see
+// apiserver.UserFrom).
+func NewAuthenticator(auth Authenticator) apiserver.Filter {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			user, ok, err := auth.AuthenticateRequest(req)
+			if err == nil && !ok {
+				err = fmt.Errorf("request is not authenticated")
+			}
+			if err != nil {
+				writeStatus(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			apiserver.SetRequestContext(req, apiserver.WithUser(apiserver.ContextFor(req), user))
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// NewRequestLogger returns a Filter that writes one line to out per
+// request, once the handler chain beneath it has finished: method, path,
+// remote addr, response status, response size in bytes, and latency.
+func NewRequestLogger(out *log.Logger) apiserver.Filter {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			wrapped, recorder := newStatusRecorder(w)
+			next.ServeHTTP(wrapped, req)
+			out.Printf("%s %s %s %d %dB %s", req.Method, req.URL.Path, req.RemoteAddr, recorder.status, recorder.bytes, time.Since(start))
+		})
+	}
+}
+
+// NewRecover returns a Filter that turns a panic anywhere in the handler
+// chain beneath it into a 500 response with an api.Status body, instead
+// of letting it tear down the server's goroutine for this connection.
+func NewRecover() apiserver.Filter {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer func() {
+				if r := recover(); r != nil {
+					writeStatus(w, http.StatusInternalServerError, fmt.Sprintf("recovered from panic: %v", r))
+				}
+			}()
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// writeStatus writes an api.Status{Status: api.StatusFailure} body. It's
+// used by filters that short-circuit before the request ever reaches the
+// apiserver's own error handling in APIServer.write.
+func writeStatus(w http.ResponseWriter, code int, msg string) {
+	body, _ := api.Encode(api.Status{Status: api.StatusFailure, Details: msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// and byte count a handler wrote, for NewRequestLogger.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// flusherRecorder and its siblings below add Flush/CloseNotify passthroughs
+// to a statusRecorder, one combination per pair of optional interfaces the
+// wrapped http.ResponseWriter implements. newStatusRecorder picks whichever
+// one matches, so a handler further down the chain that type-asserts for
+// http.Flusher or http.CloseNotifier (doWatch, disconnectContext) sees
+// exactly the same capabilities the real ResponseWriter has -- not a
+// statusRecorder that always claims both and silently no-ops the ones it
+// can't actually forward.
+type flusherRecorder struct {
+	*statusRecorder
+}
+
+func (r flusherRecorder) Flush() {
+	r.ResponseWriter.(http.Flusher).Flush()
+}
+
+type closeNotifierRecorder struct {
+	*statusRecorder
+}
+
+func (r closeNotifierRecorder) CloseNotify() <-chan bool {
+	return r.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+type flusherCloseNotifierRecorder struct {
+	*statusRecorder
+}
+
+func (r flusherCloseNotifierRecorder) Flush() {
+	r.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (r flusherCloseNotifierRecorder) CloseNotify() <-chan bool {
+	return r.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+// newStatusRecorder wraps w for NewRequestLogger, returning both the
+// wrapper to pass down the handler chain and the *statusRecorder to read
+// the captured status/bytes back from once the chain returns.
+func newStatusRecorder(w http.ResponseWriter) (http.ResponseWriter, *statusRecorder) {
+	base := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	_, isFlusher := w.(http.Flusher)
+	_, isCloseNotifier := w.(http.CloseNotifier)
+	switch {
+	case isFlusher && isCloseNotifier:
+		return flusherCloseNotifierRecorder{base}, base
+	case isFlusher:
+		return flusherRecorder{base}, base
+	case isCloseNotifier:
+		return closeNotifierRecorder{base}, base
+	default:
+		return base, base
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filters
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/apiserver"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// userEcho is returned by fakeStorage.Get so tests can see what user, if
+// any, apiserver.UserFrom found in the ctx it was handed.
+type userEcho struct {
+	api.JSONBase `json:",inline"`
+	User         string `json:"user"`
+}
+
+func init() {
+	api.AddKnownTypes("", userEcho{})
+}
+
+// fakeStorage is the minimal RESTStorage this package's tests need: a
+// Get that reports the ctx's authenticated user (or panics, for
+// TestRecoverConvertsPanicToStatus).
+type fakeStorage struct {
+	getFunc func(ctx context.Context, id string) (interface{}, error)
+}
+
+func (s *fakeStorage) List(ctx context.Context, label labels.Selector, field fields.Selector) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (s *fakeStorage) Get(ctx context.Context, id string) (interface{}, error) {
+	return s.getFunc(ctx, id)
+}
+func (s *fakeStorage) Delete(ctx context.Context, id string) (<-chan interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (s *fakeStorage) Extract(body []byte) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (s *fakeStorage) Create(ctx context.Context, obj interface{}) (<-chan interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (s *fakeStorage) Update(ctx context.Context, obj interface{}) (<-chan interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+// watchableStorage adds a WatchAll to fakeStorage, so tests can drive
+// ?watch=true through a Filter chain.
+type watchableStorage struct {
+	fakeStorage
+}
+
+func (s *watchableStorage) WatchAll(ctx context.Context, label labels.Selector, field fields.Selector) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func (s *watchableStorage) WatchSingle(ctx context.Context, id string) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+type fakeAuthenticator struct {
+	user string
+	ok   bool
+	err  error
+}
+
+func (a *fakeAuthenticator) AuthenticateRequest(req *http.Request) (string, bool, error) {
+	return a.user, a.ok, a.err
+}
+
+func TestAuthenticatorRejectsUnauthenticated(t *testing.T) {
+	storage := &fakeStorage{getFunc: func(ctx context.Context, id string) (interface{}, error) {
+		return userEcho{}, nil
+	}}
+	handler := apiserver.New(map[string]apiserver.RESTStorage{"foo": storage}, "/prefix/version",
+		NewAuthenticator(&fakeAuthenticator{ok: false}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	var status api.Status
+	if err := api.DecodeInto(body, &status); err != nil {
+		t.Fatalf("expected a decodable api.Status body, got %q: %v", body, err)
+	}
+	if status.Status != api.StatusFailure {
+		t.Errorf("expected a failure status, got %#v", status)
+	}
+}
+
+func TestAuthenticatorThreadsUserToStorage(t *testing.T) {
+	var sawUser string
+	var sawOK bool
+	storage := &fakeStorage{getFunc: func(ctx context.Context, id string) (interface{}, error) {
+		sawUser, sawOK = apiserver.UserFrom(ctx)
+		return userEcho{User: sawUser}, nil
+	}}
+	handler := apiserver.New(map[string]apiserver.RESTStorage{"foo": storage}, "/prefix/version",
+		NewAuthenticator(&fakeAuthenticator{user: "alice", ok: true}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if !sawOK || sawUser != "alice" {
+		t.Errorf("expected storage to see user %q, saw %q (ok=%v)", "alice", sawUser, sawOK)
+	}
+}
+
+func TestRecoverConvertsPanicToStatus(t *testing.T) {
+	storage := &fakeStorage{getFunc: func(ctx context.Context, id string) (interface{}, error) {
+		panic("boom")
+	}}
+	handler := apiserver.New(map[string]apiserver.RESTStorage{"foo": storage}, "/prefix/version", NewRecover())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", resp.StatusCode)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	var status api.Status
+	if err := api.DecodeInto(body, &status); err != nil {
+		t.Fatalf("expected a decodable api.Status body, got %q: %v", body, err)
+	}
+	if status.Status != api.StatusFailure || !strings.Contains(status.Details, "boom") {
+		t.Errorf("expected a failure status mentioning the panic, got %#v", status)
+	}
+}
+
+func TestRequestLoggerLogsOutcome(t *testing.T) {
+	storage := &fakeStorage{getFunc: func(ctx context.Context, id string) (interface{}, error) {
+		return userEcho{}, nil
+	}}
+	var buf bytes.Buffer
+	handler := apiserver.New(map[string]apiserver.RESTStorage{"foo": storage}, "/prefix/version",
+		NewRequestLogger(log.New(&buf, "", 0)))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/foo/bar")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	line := buf.String()
+	if !strings.Contains(line, "GET") || !strings.Contains(line, "/prefix/version/foo/bar") || !strings.Contains(line, "200") {
+		t.Errorf("expected a log line with method, path and status, got %q", line)
+	}
+}
+
+func TestRequestLoggerDoesNotBreakWatch(t *testing.T) {
+	storage := &watchableStorage{}
+	var buf bytes.Buffer
+	handler := apiserver.New(map[string]apiserver.RESTStorage{"foo": storage}, "/prefix/version",
+		NewRequestLogger(log.New(&buf, "", 0)))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/foo?watch=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expected watch to stream (200), got %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a streaming watch response, got Content-Type %q", ct)
+	}
+}
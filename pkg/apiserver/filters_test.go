@@ -0,0 +1,148 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// orderRecorder records filter entry/exit order under a lock, so a test
+// goroutine can read it while the handler goroutine is still writing to it
+// without racing.
+type orderRecorder struct {
+	lock  sync.Mutex
+	order []string
+}
+
+func (o *orderRecorder) append(s string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.order = append(o.order, s)
+}
+
+func (o *orderRecorder) snapshot() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]string(nil), o.order...)
+}
+
+func (o *orderRecorder) reset() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.order = nil
+}
+
+// markerFilter appends name to order before and after calling next, so
+// tests can assert on registration order.
+func markerFilter(name string, order *orderRecorder) Filter {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			order.append(name + ":in")
+			next.ServeHTTP(w, req)
+			order.append(name + ":out")
+		})
+	}
+}
+
+func TestFiltersRunInRegistrationOrder(t *testing.T) {
+	order := &orderRecorder{}
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version",
+		markerFilter("first", order),
+		markerFilter("second", order),
+	)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple")
+	expectNoError(t, err)
+	resp.Body.Close()
+
+	got := order.snapshot()
+	want := []string{"first:in", "second:in", "second:out", "first:out"}
+	if len(got) != len(want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got order %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestWithFiltersAddsAfterConstruction(t *testing.T) {
+	order := &orderRecorder{}
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version", markerFilter("first", order))
+	handler.WithFilters(markerFilter("second", order))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple")
+	expectNoError(t, err)
+	resp.Body.Close()
+
+	got := order.snapshot()
+	want := []string{"first:in", "second:in", "second:out", "first:out"}
+	if len(got) != len(want) {
+		t.Fatalf("got order %v, want %v", got, want)
+	}
+}
+
+func TestFiltersApplyToOperationsAndWatch(t *testing.T) {
+	order := &orderRecorder{}
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version", markerFilter("f", order))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/operations")
+	expectNoError(t, err)
+	resp.Body.Close()
+	if got := order.snapshot(); len(got) != 2 {
+		t.Errorf("expected the filter to wrap /operations, got %v", got)
+	}
+
+	order.reset()
+	resp, err = http.Get(server.URL + "/prefix/version/simple?watch=true")
+	expectNoError(t, err)
+
+	for i := 0; simpleStorage.fakeWatch == nil && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if simpleStorage.fakeWatch == nil {
+		t.Fatal("WatchAll was never called")
+	}
+	resp.Body.Close()
+
+	var got []string
+	for i := 0; i < 100; i++ {
+		got = order.snapshot()
+		if len(got) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected the filter to wrap the watch endpoint, got %v", got)
+	}
+}
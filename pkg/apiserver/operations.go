@@ -0,0 +1,71 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"net/http"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/operations"
+)
+
+// handleOperationsList serves GET /prefix/operations.
+func (s *APIServer) handleOperationsList(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		notFound(w, req)
+		return
+	}
+	ops := s.ops.List()
+	snapshots := make([]operations.Snapshot, len(ops))
+	for i, op := range ops {
+		snapshots[i] = op.Snapshot()
+	}
+	s.write(w, http.StatusOK, snapshots, nil)
+}
+
+// handleOperation serves GET and DELETE on /prefix/operations/{id}. GET
+// supports ?wait=true&timeout=... to long-poll until the operation
+// completes or the timeout elapses.
+func (s *APIServer) handleOperation(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, s.prefix+"/operations/")
+	if id == "" {
+		notFound(w, req)
+		return
+	}
+	op := s.ops.Get(id)
+	if op == nil {
+		s.write(w, http.StatusOK, nil, NewNotFoundErr("operation", id))
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		if req.FormValue("wait") == "true" {
+			ctx, cancel := context.WithTimeout(context.Background(), parseTimeout(req.FormValue("timeout")))
+			op.WaitFor(ctx)
+			cancel()
+		}
+		s.write(w, http.StatusOK, op.Snapshot(), nil)
+	case "DELETE":
+		err := op.Cancel()
+		s.write(w, http.StatusOK, op.Snapshot(), err)
+	default:
+		notFound(w, req)
+	}
+}
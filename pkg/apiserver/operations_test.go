@@ -0,0 +1,156 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/operations"
+)
+
+func TestOperationsCreateReturnsOpID(t *testing.T) {
+	storage := &SimpleRESTStorage{
+		injectedFunction: func(ctx context.Context, obj interface{}) (interface{}, error) {
+			time.Sleep(100 * time.Millisecond)
+			return obj, nil
+		},
+	}
+	handler := New(map[string]RESTStorage{"foo": storage}, "/prefix/version")
+	handler.asyncOpWait = 0
+	server := httptest.NewServer(handler)
+
+	simple := Simple{Name: "foo"}
+	data, _ := api.Encode(simple)
+	resp, err := http.Post(server.URL+"/prefix/version/foo", "application/json", bytes.NewBuffer(data))
+	expectNoError(t, err)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	var status api.Status
+	extractBody(resp, &status)
+	if status.Details == "" {
+		t.Errorf("expected an operation id in Details, got %#v", status)
+	}
+	if handler.ops.Get(status.Details) == nil {
+		t.Errorf("operation %s not registered", status.Details)
+	}
+	wantLocation := "/prefix/version/operations/" + status.Details
+	if got := resp.Header.Get("Location"); got != wantLocation {
+		t.Errorf("expected Location %q, got %q", wantLocation, got)
+	}
+}
+
+func TestOperationsListOverHTTP(t *testing.T) {
+	handler := New(map[string]RESTStorage{}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ch := make(chan interface{}, 1)
+	op := handler.ops.NewOperation(ch, "create")
+	ch <- "done"
+
+	resp, err := http.Get(server.URL + "/prefix/version/operations")
+	expectNoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var snapshots []operations.Snapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snapshots); err != nil {
+		t.Fatalf("couldn't decode list response: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].ID != op.ID {
+		t.Errorf("expected a single snapshot for operation %s, got %#v", op.ID, snapshots)
+	}
+}
+
+func TestOperationsGetWaitOverHTTP(t *testing.T) {
+	handler := New(map[string]RESTStorage{}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ch := make(chan interface{})
+	op := handler.ops.NewOperation(ch, "create")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ch <- "done"
+	}()
+
+	resp, err := http.Get(server.URL + "/prefix/version/operations/" + op.ID + "?wait=true&timeout=1s")
+	expectNoError(t, err)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var snap operations.Snapshot
+	extractBody(resp, &snap)
+	if snap.State != operations.Done {
+		t.Errorf("expected wait to block until the operation finished, got state %s", snap.State)
+	}
+}
+
+func TestOperationsGetMissingReturnsNotFound(t *testing.T) {
+	handler := New(map[string]RESTStorage{}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/operations/does-not-exist")
+	expectNoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestOperationsDeleteCancelsOverHTTP(t *testing.T) {
+	handler := New(map[string]RESTStorage{}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ch := make(chan interface{})
+	op := handler.ops.NewOperation(ch, "delete")
+
+	req, err := http.NewRequest("DELETE", server.URL+"/prefix/version/operations/"+op.ID, nil)
+	expectNoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	expectNoError(t, err)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var snap operations.Snapshot
+	extractBody(resp, &snap)
+	if snap.State != operations.Cancelled {
+		t.Errorf("expected DELETE to cancel the operation immediately, got state %s", snap.State)
+	}
+
+	ch <- "too late"
+	waitResp, err := http.Get(server.URL + "/prefix/version/operations/" + op.ID + "?wait=true&timeout=1s")
+	expectNoError(t, err)
+	extractBody(waitResp, &snap)
+	if snap.State != operations.Cancelled {
+		t.Errorf("expected the operation to stay Cancelled once its result arrived, got state %s", snap.State)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/fields"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/labels"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+)
+
+// watchEvent is the newline-delimited JSON frame written for each
+// watch.Event in chunked mode.
+type watchEvent struct {
+	Type   watch.EventType `json:"type"`
+	Object interface{}     `json:"object"`
+}
+
+// doWatch serves GET /prefix/{kind}?watch=true[&id=...][&labels=...&fields=...].
+// storage must implement ResourceWatcher. It streams events until the
+// storage's result channel closes or the client disconnects, dropping any
+// event that doesn't match label/field first so slow clients don't pay
+// for objects they didn't ask for.
+func (s *APIServer) doWatch(ctx context.Context, w http.ResponseWriter, req *http.Request, storage RESTStorage, id string, label labels.Selector, field fields.Selector) {
+	watcher, ok := storage.(ResourceWatcher)
+	if !ok {
+		s.write(w, http.StatusOK, nil, fmt.Errorf("storage does not support watch"))
+		return
+	}
+
+	var watchIface watch.Interface
+	var err error
+	if id != "" {
+		watchIface, err = watcher.WatchSingle(ctx, id)
+	} else {
+		watchIface, err = watcher.WatchAll(ctx, label, field)
+	}
+	if err != nil {
+		s.write(w, http.StatusOK, nil, err)
+		return
+	}
+	defer watchIface.Stop()
+
+	attrGetter, _ := storage.(AttrGetter)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.write(w, http.StatusOK, nil, fmt.Errorf("unable to stream this response"))
+		return
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Transfer-Encoding", "chunked")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var closeNotify <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closeNotify = notifier.CloseNotify()
+	}
+
+	for {
+		select {
+		case event, open := <-watchIface.ResultChan():
+			if !open {
+				return
+			}
+			if !matchesEvent(attrGetter, label, field, event) {
+				continue
+			}
+			if sse {
+				writeSSEEvent(w, event)
+			} else {
+				writeJSONEvent(w, event)
+			}
+			flusher.Flush()
+		case <-closeNotify:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// matchesEvent reports whether event.Object matches label and field. If
+// storage didn't provide an AttrGetter there's nothing to filter on, so
+// every event passes through.
+func matchesEvent(attrGetter AttrGetter, label labels.Selector, field fields.Selector, event watch.Event) bool {
+	if attrGetter == nil {
+		return true
+	}
+	ls, fs, err := attrGetter.GetAttrs(event.Object)
+	if err != nil {
+		return true
+	}
+	return label.Matches(ls) && field.Matches(fs)
+}
+
+func writeJSONEvent(w http.ResponseWriter, event watch.Event) {
+	body, err := api.Encode(watchEvent{Type: event.Type, Object: event.Object})
+	if err != nil {
+		return
+	}
+	w.Write(body)
+	w.Write([]byte("\n"))
+}
+
+func writeSSEEvent(w http.ResponseWriter, event watch.Event) {
+	body, err := api.Encode(event.Object)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, body)
+}
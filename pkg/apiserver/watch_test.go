@@ -0,0 +1,165 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apiserver
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchJSON(t *testing.T) {
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple?watch=true")
+	expectNoError(t, err)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status: %d", resp.StatusCode)
+	}
+
+	// Give the handler a moment to call WatchAll and install the fake.
+	for i := 0; simpleStorage.fakeWatch == nil && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if simpleStorage.fakeWatch == nil {
+		t.Fatal("WatchAll was never called")
+	}
+
+	simpleStorage.fakeWatch.Add(Simple{Name: "added"})
+	simpleStorage.fakeWatch.Modify(Simple{Name: "modified"})
+	simpleStorage.fakeWatch.Delete(Simple{Name: "deleted"})
+
+	reader := bufio.NewReader(resp.Body)
+	for _, want := range []string{"ADDED", "MODIFIED", "DELETED"} {
+		line, err := reader.ReadString('\n')
+		expectNoError(t, err)
+		if !strings.Contains(line, want) {
+			t.Errorf("expected a %s frame, got %q", want, line)
+		}
+	}
+}
+
+func TestWatchSSE(t *testing.T) {
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL+"/prefix/version/simple?watch=true", nil)
+	expectNoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	expectNoError(t, err)
+	defer resp.Body.Close()
+
+	for i := 0; simpleStorage.fakeWatch == nil && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if simpleStorage.fakeWatch == nil {
+		t.Fatal("WatchAll was never called")
+	}
+	simpleStorage.fakeWatch.Add(Simple{Name: "added"})
+
+	reader := bufio.NewReader(resp.Body)
+	eventLine, err := reader.ReadString('\n')
+	expectNoError(t, err)
+	if !strings.HasPrefix(eventLine, "event: ADDED") {
+		t.Errorf("expected an SSE event line, got %q", eventLine)
+	}
+	dataLine, err := reader.ReadString('\n')
+	expectNoError(t, err)
+	if !strings.HasPrefix(dataLine, "data: ") {
+		t.Errorf("expected an SSE data line, got %q", dataLine)
+	}
+}
+
+func TestWatchFiltersOnLabelAndField(t *testing.T) {
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple?watch=true&labels=env!=test")
+	expectNoError(t, err)
+	defer resp.Body.Close()
+
+	for i := 0; simpleStorage.fakeWatch == nil && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if simpleStorage.fakeWatch == nil {
+		t.Fatal("WatchAll was never called")
+	}
+
+	simpleStorage.fakeWatch.Add(Simple{Name: "dropped", Labels: map[string]string{"env": "test"}})
+	simpleStorage.fakeWatch.Add(Simple{Name: "kept", Labels: map[string]string{"env": "prod"}})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	expectNoError(t, err)
+	if !strings.Contains(line, "kept") {
+		t.Errorf("expected the env=test event to be filtered out, got %q", line)
+	}
+}
+
+func TestWatchStopsOnClientDisconnect(t *testing.T) {
+	simpleStorage := &SimpleRESTStorage{}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple?watch=true")
+	expectNoError(t, err)
+
+	for i := 0; simpleStorage.fakeWatch == nil && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if simpleStorage.fakeWatch == nil {
+		t.Fatal("WatchAll was never called")
+	}
+
+	resp.Body.Close()
+
+	select {
+	case <-simpleStorage.watcher.stopped:
+	case <-time.After(time.Second):
+		t.Errorf("expected the watch to be stopped after the client disconnected")
+	}
+}
+
+func TestWatchStorageError(t *testing.T) {
+	simpleStorage := &SimpleRESTStorage{
+		errors: map[string]error{"watchAll": NewNotFoundErr("simple", "")},
+	}
+	handler := New(map[string]RESTStorage{"simple": simpleStorage}, "/prefix/version")
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/prefix/version/simple?watch=true")
+	expectNoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 before any chunk was written, got %d", resp.StatusCode)
+	}
+}
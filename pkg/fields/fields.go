@@ -0,0 +1,42 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fields mirrors pkg/labels, but matches on an object's fields
+// (e.g. a pod's status.phase) rather than its user-supplied labels.
+package fields
+
+// Fields allows you to present field values for matching against a
+// Selector, without committing to a concrete representation.
+type Fields interface {
+	// Has returns whether the provided field exists.
+	Has(field string) bool
+	// Get returns the value for the provided field.
+	Get(field string) string
+}
+
+// Set is a map of field:value. It implements Fields.
+type Set map[string]string
+
+// Has returns whether the provided field exists in the map.
+func (ls Set) Has(field string) bool {
+	_, exists := ls[field]
+	return exists
+}
+
+// Get returns the value for the provided field.
+func (ls Set) Get(field string) string {
+	return ls[field]
+}
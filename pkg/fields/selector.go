@@ -0,0 +1,111 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fields
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector represents a field selector, matching a Fields value against a
+// conjunction of "key=value" / "key!=value" requirements.
+type Selector interface {
+	// Matches returns true if this selector matches the given field set.
+	Matches(Fields) bool
+	// Empty returns true if this selector does not restrict the match at all.
+	Empty() bool
+	// String returns a human readable string that represents this selector.
+	String() string
+}
+
+// Everything returns a Selector that matches all Fields.
+func Everything() Selector {
+	return andTerm{}
+}
+
+type hasTerm struct {
+	field, value string
+}
+
+func (t *hasTerm) Matches(ls Fields) bool { return ls.Get(t.field) == t.value }
+func (t *hasTerm) Empty() bool            { return false }
+func (t *hasTerm) String() string         { return fmt.Sprintf("%s=%s", t.field, t.value) }
+
+type notHasTerm struct {
+	field, value string
+}
+
+func (t *notHasTerm) Matches(ls Fields) bool { return ls.Get(t.field) != t.value }
+func (t *notHasTerm) Empty() bool            { return false }
+func (t *notHasTerm) String() string         { return fmt.Sprintf("%s!=%s", t.field, t.value) }
+
+// andTerm is the conjunction of zero or more Selectors; an empty andTerm
+// matches everything.
+type andTerm []Selector
+
+func (t andTerm) Matches(ls Fields) bool {
+	for _, q := range t {
+		if !q.Matches(ls) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t andTerm) Empty() bool {
+	return len(t) == 0
+}
+
+func (t andTerm) String() string {
+	terms := make([]string, len(t))
+	for i, q := range t {
+		terms[i] = q.String()
+	}
+	return strings.Join(terms, ",")
+}
+
+// ParseSelector parses a comma-separated conjunction of "key=value" and
+// "key!=value" terms into a Selector.
+func ParseSelector(selector string) (Selector, error) {
+	parts := strings.Split(selector, ",")
+	sort.Strings(parts)
+	var terms []Selector
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid field selector term: %q", part)
+			}
+			terms = append(terms, &notHasTerm{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid field selector term: %q", part)
+			}
+			terms = append(terms, &hasTerm{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+		default:
+			return nil, fmt.Errorf("invalid field selector term: %q", part)
+		}
+	}
+	return andTerm(terms), nil
+}
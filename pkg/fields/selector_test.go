@@ -0,0 +1,49 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fields
+
+import "testing"
+
+func TestEverythingMatches(t *testing.T) {
+	if !Everything().Matches(Set{"a": "b"}) {
+		t.Error("Everything() should match any field set")
+	}
+}
+
+func TestParseSelectorMatches(t *testing.T) {
+	selector, err := ParseSelector("name=foo,env!=prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !selector.Matches(Set{"name": "foo", "env": "test"}) {
+		t.Error("expected selector to match name=foo,env!=prod")
+	}
+	if selector.Matches(Set{"name": "foo", "env": "prod"}) {
+		t.Error("expected selector to reject env=prod")
+	}
+	if selector.Matches(Set{"name": "bar", "env": "test"}) {
+		t.Error("expected selector to reject name=bar")
+	}
+}
+
+func TestParseSelectorInvalid(t *testing.T) {
+	for _, bad := range []string{"=foo", "foo", "!=foo"} {
+		if _, err := ParseSelector(bad); err == nil {
+			t.Errorf("expected an error parsing %q", bad)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package operations tracks long-running async REST mutations (the
+// channel a RESTStorage's Create/Update/Delete returns) so a client can
+// poll, wait on, or request cancellation of one after the initial request
+// returned.
+package operations
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.google.com/p/go.net/context"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// DefaultTTL is the TTL NewOperations uses when given a zero ttl: how
+// long a completed Operation is kept around for clients that haven't yet
+// polled it, before the reaper evicts it.
+const DefaultTTL = 10 * time.Minute
+
+// DefaultReapInterval is the reap interval NewOperations uses when given
+// a zero reapInterval: how often the reaper sweeps for expired operations.
+const DefaultReapInterval = 1 * time.Minute
+
+// Operation states. An Operation is created Running (no Operation is ever
+// given the Pending state) and ends in exactly one of Done, Failed or
+// Cancelled.
+const (
+	Pending   = "Pending"
+	Running   = "Running"
+	Done      = "Done"
+	Failed    = "Failed"
+	Cancelled = "Cancelled"
+)
+
+// Operation tracks a single piece of async work so that a client can
+// poll, wait on, or request cancellation of it after the initial request
+// returned.
+type Operation struct {
+	ID      string
+	Kind    string
+	Started time.Time
+
+	lock      sync.Mutex
+	state     string
+	finished  time.Time
+	result    interface{}
+	notify    chan struct{}
+	cancelled bool
+}
+
+// newOperation creates an Operation in the Running state and starts a
+// goroutine that waits for channel to deliver its single result.
+func newOperation(id, kind string, channel <-chan interface{}) *Operation {
+	op := &Operation{
+		ID:      id,
+		Kind:    kind,
+		Started: time.Now(),
+		state:   Running,
+		notify:  make(chan struct{}),
+	}
+	go op.wait(channel)
+	return op
+}
+
+func (op *Operation) wait(channel <-chan interface{}) {
+	result := <-channel
+
+	op.lock.Lock()
+	defer op.lock.Unlock()
+	if op.cancelled {
+		op.state = Cancelled
+	} else if _, isErr := result.(error); isErr {
+		op.state = Failed
+	} else {
+		op.state = Done
+	}
+	op.result = result
+	op.finished = time.Now()
+	close(op.notify)
+}
+
+// WaitFor blocks until the operation completes or ctx is done, returning
+// the final result and true if it completed first. If ctx is already done
+// by the time WaitFor sees the completion, it returns false. The operation
+// itself keeps running to completion regardless of which one wins.
+//
+// When ctx is the same context already handed to the storage call that
+// produced this operation, ctx firing and the operation's own result
+// channel receiving a ctx-deadline error are not an independent race: the
+// latter is strictly downstream of the former (via the extra goroutine
+// hop that turns it into a result), so ctx is always done by the time
+// that result arrives and WaitFor returns false rather than surfacing
+// that deadline as a failed result.
+func (op *Operation) WaitFor(ctx context.Context) (interface{}, bool) {
+	select {
+	case <-op.notify:
+		select {
+		case <-ctx.Done():
+			return nil, false
+		default:
+		}
+		op.lock.Lock()
+		defer op.lock.Unlock()
+		return op.result, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// Cancel requests that the operation be marked Cancelled. The caller's own
+// request context is long gone by the time a client comes back to cancel
+// an operation it polled, so this only flips the recorded state for
+// operations that haven't completed yet; it has no way to interrupt
+// whatever goroutine is already running the work.
+func (op *Operation) Cancel() error {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+	select {
+	case <-op.notify:
+		return fmt.Errorf("operation %s already finished", op.ID)
+	default:
+	}
+	op.cancelled = true
+	op.state = Cancelled
+	return nil
+}
+
+// Snapshot is the JSON-friendly view of an Operation returned by the
+// list/get endpoints.
+type Snapshot struct {
+	api.JSONBase `json:",inline"`
+	ID           string      `json:"id"`
+	Kind         string      `json:"kind"`
+	State        string      `json:"state"`
+	Started      time.Time   `json:"started"`
+	Finished     *time.Time  `json:"finished,omitempty"`
+	Result       interface{} `json:"result,omitempty"`
+}
+
+// Snapshot returns the JSON-friendly view of op.
+func (op *Operation) Snapshot() Snapshot {
+	op.lock.Lock()
+	defer op.lock.Unlock()
+	snap := Snapshot{
+		ID:      op.ID,
+		Kind:    op.Kind,
+		State:   op.state,
+		Started: op.Started,
+	}
+	select {
+	case <-op.notify:
+		finished := op.finished
+		snap.Finished = &finished
+		if err, isErr := op.result.(error); isErr {
+			snap.Result = api.Status{Status: api.StatusFailure, Details: err.Error()}
+		} else {
+			snap.Result = op.result
+		}
+	default:
+	}
+	return snap
+}
+
+// Operations is the registry of in-flight and recently completed
+// Operations for a single APIServer.
+type Operations struct {
+	lock         sync.Mutex
+	ops          map[string]*Operation
+	counter      uint64
+	ttl          time.Duration
+	reapInterval time.Duration
+}
+
+// NewOperations creates an empty Operations registry and starts its
+// background reaper. ttl controls how long a completed Operation is kept
+// around before the reaper evicts it, and reapInterval controls how often
+// the reaper sweeps; a zero value for either uses DefaultTTL or
+// DefaultReapInterval.
+func NewOperations(ttl, reapInterval time.Duration) *Operations {
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if reapInterval == 0 {
+		reapInterval = DefaultReapInterval
+	}
+	ops := &Operations{ops: map[string]*Operation{}, ttl: ttl, reapInterval: reapInterval}
+	go ops.reap()
+	return ops
+}
+
+// NewOperation registers channel as a new in-flight operation of the
+// given kind (e.g. "create", "update", "delete") and returns it.
+func (ops *Operations) NewOperation(channel <-chan interface{}, kind string) *Operation {
+	id := strconv.FormatUint(atomic.AddUint64(&ops.counter, 1), 36)
+	op := newOperation(id, kind, channel)
+
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+	ops.ops[id] = op
+	return op
+}
+
+// Get returns the operation with the given id, if any.
+func (ops *Operations) Get(id string) *Operation {
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+	return ops.ops[id]
+}
+
+// List returns all tracked operations (active and recently completed), in
+// no particular order.
+func (ops *Operations) List() []*Operation {
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+	result := make([]*Operation, 0, len(ops.ops))
+	for _, op := range ops.ops {
+		result = append(result, op)
+	}
+	return result
+}
+
+// reap evicts operations that finished more than ops.ttl ago.
+func (ops *Operations) reap() {
+	ticker := time.NewTicker(ops.reapInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ops.sweep(time.Now())
+	}
+}
+
+func (ops *Operations) sweep(now time.Time) {
+	ops.lock.Lock()
+	defer ops.lock.Unlock()
+	for id, op := range ops.ops {
+		op.lock.Lock()
+		finished := !op.finished.IsZero() && now.Sub(op.finished) > ops.ttl
+		op.lock.Unlock()
+		if finished {
+			delete(ops.ops, id)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package operations
+
+import (
+	"testing"
+	"time"
+
+	"code.google.com/p/go.net/context"
+)
+
+// waitCtx builds the context.Context WaitFor wants out of a plain
+// duration, for tests that don't care about sharing it with anything else
+// (see WaitFor's doc for when that sharing matters).
+func waitCtx(d time.Duration) context.Context {
+	ctx, _ := context.WithTimeout(context.Background(), d)
+	return ctx
+}
+
+func TestOperationsPollUntilDone(t *testing.T) {
+	ops := NewOperations(0, 0)
+	ch := make(chan interface{}, 1)
+	op := ops.NewOperation(ch, "create")
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ch <- "done"
+	}()
+	go func() {
+		op.WaitFor(waitCtx(time.Second))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("operation never completed")
+	}
+
+	snap := op.Snapshot()
+	if snap.State != Done {
+		t.Errorf("expected state %s, got %s", Done, snap.State)
+	}
+}
+
+func TestOperationsWaitWithTimeout(t *testing.T) {
+	ops := NewOperations(0, 0)
+	ch := make(chan interface{})
+	op := ops.NewOperation(ch, "create")
+
+	start := time.Now()
+	_, ok := op.WaitFor(waitCtx(50 * time.Millisecond))
+	if ok {
+		t.Errorf("expected WaitFor to time out")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("WaitFor returned before its timeout elapsed")
+	}
+}
+
+func TestOperationsCancelInFlight(t *testing.T) {
+	ops := NewOperations(0, 0)
+	ch := make(chan interface{})
+	op := ops.NewOperation(ch, "delete")
+
+	if err := op.Cancel(); err != nil {
+		t.Fatalf("unexpected error cancelling: %v", err)
+	}
+
+	ch <- "too late"
+	op.WaitFor(waitCtx(time.Second))
+
+	snap := op.Snapshot()
+	if snap.State != Cancelled {
+		t.Errorf("expected state %s, got %s", Cancelled, snap.State)
+	}
+}
+
+func TestOperationsReaperEviction(t *testing.T) {
+	ops := NewOperations(0, 0)
+	ch := make(chan interface{}, 1)
+	op := ops.NewOperation(ch, "create")
+	ch <- "finished"
+	op.WaitFor(waitCtx(time.Second))
+
+	op.lock.Lock()
+	op.finished = time.Now().Add(-2 * DefaultTTL)
+	op.lock.Unlock()
+
+	ops.sweep(time.Now())
+
+	if ops.Get(op.ID) != nil {
+		t.Errorf("expected expired operation to be reaped")
+	}
+}
+
+func TestOperationsReaperEvictionWithConfigurableTTL(t *testing.T) {
+	ttl := 50 * time.Millisecond
+	ops := NewOperations(ttl, time.Hour)
+	ch := make(chan interface{}, 1)
+	op := ops.NewOperation(ch, "create")
+	ch <- "finished"
+	op.WaitFor(waitCtx(time.Second))
+
+	// Not yet past the configured TTL: sweep should leave it alone.
+	ops.sweep(time.Now())
+	if ops.Get(op.ID) == nil {
+		t.Fatalf("operation evicted before its configured TTL elapsed")
+	}
+
+	ops.sweep(time.Now().Add(2 * ttl))
+	if ops.Get(op.ID) != nil {
+		t.Errorf("expected operation to be reaped once its configured TTL elapsed")
+	}
+}